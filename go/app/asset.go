@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// AssetStorage is the backend that stores uploaded item images. Handlers
+// never touch os or an S3 client directly; they go through an AssetAgent,
+// which makes the backend swappable via ASSET_BACKEND.
+type AssetStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (url string, err error)
+	Get(ctx context.Context, key string) (rc io.ReadCloser, contentType string, err error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// AssetAgent adds content-hash dedup on top of an AssetStorage: storing the
+// same bytes twice returns the existing key instead of writing again.
+type AssetAgent struct {
+	storage AssetStorage
+}
+
+func NewAssetAgent(storage AssetStorage) *AssetAgent {
+	return &AssetAgent{storage: storage}
+}
+
+// NewAssetAgentFromEnv picks the storage backend named by ASSET_BACKEND
+// ("local", the default, or "s3").
+func NewAssetAgentFromEnv() (*AssetAgent, error) {
+	switch os.Getenv("ASSET_BACKEND") {
+	case "s3":
+		storage, err := NewS3StorageFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewAssetAgent(storage), nil
+	case "", "local":
+		return NewAssetAgent(NewLocalStorage(ImgDirRelative)), nil
+	default:
+		return nil, fmt.Errorf("unknown ASSET_BACKEND %q", os.Getenv("ASSET_BACKEND"))
+	}
+}
+
+// Store saves data under a key derived from its SHA-256 content hash and
+// reports whether that key already existed, so callers can skip any
+// per-upload work that only needs to happen once per distinct image.
+func (a *AssetAgent) Store(ctx context.Context, data []byte, contentType, ext string) (key string, duplicate bool, err error) {
+	sum := sha256.Sum256(data)
+	key = fmt.Sprintf("%x%s", sum, ext)
+
+	exists, err := a.storage.Exists(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return key, true, nil
+	}
+
+	if _, err := a.storage.Put(ctx, key, bytesReader(data), contentType); err != nil {
+		return "", false, err
+	}
+	return key, false, nil
+}
+
+func (a *AssetAgent) Open(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	return a.storage.Get(ctx, key)
+}
+
+func bytesReader(data []byte) io.Reader {
+	return &byteSliceReader{data: data}
+}
+
+// byteSliceReader avoids pulling in "bytes" just for a one-shot Reader.
+type byteSliceReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *byteSliceReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// LocalStorage implements AssetStorage on the local filesystem, preserving
+// the behavior the handlers used to implement inline.
+type LocalStorage struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	dst, err := os.Create(path.Join(s.dir, key))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, r); err != nil {
+		return "", err
+	}
+	return path.Join("/image", key), nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	f, err := os.Open(path.Join(s.dir, key))
+	if err != nil {
+		return nil, "", err
+	}
+	return f, "image/jpeg", nil
+}
+
+func (s *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(path.Join(s.dir, key))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	return os.Remove(path.Join(s.dir, key))
+}
+
+// S3Storage implements AssetStorage against an S3-compatible bucket,
+// configured via S3_BUCKET / S3_ENDPOINT / S3_REGION.
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewS3StorageFromEnv() (*S3Storage, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET is required for the s3 asset backend")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion(os.Getenv("S3_REGION")))
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &S3Storage{client: client, bucket: bucket}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/image/%s", key), nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := ""
+	if out.ContentType != nil {
+		contentType = *out.ContentType
+	}
+	return out.Body, contentType, nil
+}
+
+func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+const blurHashAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurHash produces a compact BlurHash string for img, encoding
+// xComponents by yComponents DCT coefficients per the standard BlurHash
+// algorithm (https://github.com/woltapp/blurhash). The frontend decodes it
+// into a tiny placeholder to paint before the full image has loaded.
+func encodeBlurHash(img image.Image, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	factors := make([][3]float64, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			factors[j*xComponents+i] = blurHashComponent(img, bounds, w, h, i, j)
+		}
+	}
+
+	dc := factors[0]
+	ac := factors[1:]
+
+	var maxValue float64
+	sizeFlag := int64((xComponents - 1) + (yComponents-1)*9)
+	out := encodeBase83(sizeFlag, 1)
+
+	if len(ac) > 0 {
+		var maxAC float64
+		for _, c := range ac {
+			for _, v := range c {
+				if a := math.Abs(v); a > maxAC {
+					maxAC = a
+				}
+			}
+		}
+		quantisedMax := int64(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		out = append(out, encodeBase83(quantisedMax, 1)...)
+	} else {
+		maxValue = 1
+		out = append(out, encodeBase83(0, 1)...)
+	}
+
+	out = append(out, encodeBase83(encodeDC(dc), 4)...)
+	for _, c := range ac {
+		out = append(out, encodeBase83(encodeAC(c, maxValue), 2)...)
+	}
+
+	return string(out)
+}
+
+func blurHashComponent(img image.Image, bounds image.Rectangle, w, h, i, j int) [3]float64 {
+	var r, g, b, total float64
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1.0
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			basis := normalisation *
+				math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+				math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+
+			cr, cg, cb, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			r += basis * srgbToLinear(cr)
+			g += basis * srgbToLinear(cg)
+			b += basis * srgbToLinear(cb)
+			total++
+		}
+	}
+
+	scale := 1.0 / total
+	return [3]float64{r * scale, g * scale, b * scale}
+}
+
+func srgbToLinear(c uint32) float64 {
+	v := float64(c>>8) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int64 {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int64(v*12.92*255 + 0.5)
+	}
+	return int64((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func encodeDC(c [3]float64) int64 {
+	return linearToSrgb(c[0])<<16 | linearToSrgb(c[1])<<8 | linearToSrgb(c[2])
+}
+
+func encodeAC(c [3]float64, maxValue float64) int64 {
+	quantise := func(v float64) int64 {
+		q := int64(math.Floor(signPow(v/maxValue, 0.5)*9 + 9.5))
+		if q < 0 {
+			return 0
+		}
+		if q > 18 {
+			return 18
+		}
+		return q
+	}
+	return quantise(c[0])*19*19 + quantise(c[1])*19 + quantise(c[2])
+}
+
+func signPow(v, exp float64) float64 {
+	if v < 0 {
+		return -math.Pow(-v, exp)
+	}
+	return math.Pow(v, exp)
+}
+
+func encodeBase83(value int64, length int) []byte {
+	out := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / pow83(length-i)) % 83
+		out[i-1] = blurHashAlphabet[digit]
+	}
+	return out
+}
+
+func pow83(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 83
+	}
+	return p
+}
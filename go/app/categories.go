@@ -0,0 +1,38 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func getCategories(c echo.Context) error {
+	categories, err := categoryRepo.List()
+	if err != nil {
+		c.Logger().Errorf("Failed to get categories: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, categories)
+}
+
+func getCategoryItems(c echo.Context) error {
+	id := c.Param("categoryID")
+
+	if _, err := categoryRepo.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, Response{Message: "category not found"})
+		}
+		c.Logger().Errorf("Failed to get category: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+
+	items, err := itemRepo.ListByCategory(id)
+	if err != nil {
+		c.Logger().Errorf("Failed to get items: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+// extensionByContentType is the set of image formats accepted on upload.
+var extensionByContentType = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// sniffContentType identifies an upload by its content rather than its
+// filename extension, so a mislabeled or extension-less file is handled
+// the same as a correctly named one.
+func sniffContentType(data []byte) (contentType, ext string, err error) {
+	header := data
+	if len(header) > 512 {
+		header = header[:512]
+	}
+	contentType = http.DetectContentType(header)
+
+	ext, ok := extensionByContentType[contentType]
+	if !ok {
+		return "", "", badRequest("unsupported image content type %q", contentType)
+	}
+	return contentType, ext, nil
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
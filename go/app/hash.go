@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// dHash computes a 64-bit perceptual difference hash of img. Images that
+// look alike produce hashes with a small Hamming distance, which lets
+// getSimilarItems rank near-duplicates without doing a pixel-by-pixel
+// comparison.
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	gray := shrinkToGray(img, w, h)
+
+	var hash uint64
+	var bit uint
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1 << bit
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// shrinkToGray downsamples img to w x h using nearest-neighbor sampling and
+// converts it to 8-bit grayscale.
+func shrinkToGray(img image.Image, w, h int) [][]uint8 {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	out := make([][]uint8, h)
+	for y := 0; y < h; y++ {
+		out[y] = make([]uint8, w)
+		sy := bounds.Min.Y + y*sh/h
+		for x := 0; x < w; x++ {
+			sx := bounds.Min.X + x*sw/w
+			out[y][x] = color.GrayModel.Convert(img.At(sx, sy)).(color.Gray).Y
+		}
+	}
+	return out
+}
+
+// hammingDistance returns the number of bits that differ between a and b.
+// It is registered as the SQLite `hamming` function so /items/similar can
+// order rows by phash closeness directly in the query.
+func hammingDistance(a, b int64) int64 {
+	x := uint64(a) ^ uint64(b)
+	var count int64
+	for x != 0 {
+		count++
+		x &= x - 1
+	}
+	return count
+}
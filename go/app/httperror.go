@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// requestError marks a failure as the client's fault (bad input) rather
+// than the server's, so handlers can respond with the right status instead
+// of flattening every error to 500.
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string { return e.message }
+
+// badRequest wraps a validation failure (oversized upload, unsupported
+// content type, corrupt image) as a 400 instead of letting it read back as
+// an opaque 500.
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// itemUploadError maps an addItemToDatabase failure to a response: a
+// requestError reports the client's own mistake back to them, anything
+// else is an actual server error.
+func itemUploadError(c echo.Context, err error) error {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		c.Logger().Warnf("Rejected item upload: %s", reqErr.message)
+		return c.JSON(reqErr.status, Response{Message: reqErr.message})
+	}
+
+	c.Logger().Errorf("Failed to add item to database: %s", err)
+	return c.JSON(http.StatusInternalServerError, Response{Message: "internal server error"})
+}
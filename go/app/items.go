@@ -1,11 +1,23 @@
 package main
 
 type (
+	Response struct {
+		Message string `json:"message"`
+	}
+	Items struct {
+		Items []Item `json:"items"`
+	}
 	Item struct {
 		ID            int    `json:"id"`
 		Name          string `json:"name"`
-		CategoryID    int    `json:"categoryID"`
+		CategoryID    int    `json:"categoryId"`
+		Category      string `json:"category"`
 		ImageFileName string `json:"imageFileName"`
+		PHash         int64  `json:"-"`
+		BlurHash      string `json:"blurHash"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		ContentType   string `json:"contentType"`
 	}
 	Category struct {
 		ID   int    `json:"id"`
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
@@ -10,13 +12,27 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path"
-	"strings"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxUploadBytes caps the size of an item image upload.
+const maxUploadBytes = 5 << 20 // 5 MB
+
+// assetAgent is the storage backend for uploaded item images, selected by
+// ASSET_BACKEND and opened once in main.
+var assetAgent *AssetAgent
+
+// db, itemRepo and categoryRepo are opened/prepared once in main and shared
+// by every request instead of each handler opening its own connection.
+var (
+	db           *sql.DB
+	itemRepo     *ItemRepository
+	categoryRepo *CategoryRepository
 )
 
 const (
@@ -24,23 +40,20 @@ const (
 	ImgDirRelative = "../" + ImgDir
 	ItemFile       = "items.json"
 	ItemsTable     = "../../db/items.db"
-)
 
-type (
-	Response struct {
-		Message string `json:"message"`
-	}
-	Items struct {
-		Items []Item `json:"items"`
-	}
-	Item struct {
-		ID            int    `json:"id"`
-		Name          string `json:"name"`
-		Category      string `json:"category"`
-		ImageFileName string `json:"imageFileName"`
-	}
+	// sqliteDriver is registered in init with a custom `hamming` SQL
+	// function so /items/similar can rank rows by phash closeness.
+	sqliteDriver = "sqlite3_with_hamming"
 )
 
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("hamming", hammingDistance, true)
+		},
+	})
+}
+
 func root(c echo.Context) error {
 	res := Response{Message: "Hello, world!"}
 	return c.JSON(http.StatusOK, res)
@@ -50,21 +63,26 @@ func addItem(c echo.Context) error {
 	// Get form data
 	name := c.FormValue("name")
 	category := c.FormValue("category")
-	image, error := c.FormFile("image")
-	if error != nil {
-		return c.JSON(http.StatusBadRequest, error)
+	image, err := c.FormFile("image")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
 	}
 
 	c.Logger().Infof("Receive item: %s", name)
 	c.Logger().Infof("Receive category: %s", category)
 	c.Logger().Infof("Receive image: %s", image.Filename)
 
-	err := addItemToDatabase(name, category, image)
+	if wantsEventStream(c) {
+		return addItemSSE(c, name, category, image)
+	}
+
+	item, duplicate, err := addItemToDatabase(name, category, image, noopProgress)
 	if err != nil {
-		c.Logger().Errorf("Failed to add item to database: %s", err)
-		return c.JSON(http.StatusInternalServerError, err)
+		return itemUploadError(c, err)
+	}
+	if duplicate {
+		c.Logger().Infof("Duplicate image upload, reusing item %d", item.ID)
 	}
-	saveImageToLocal(image)
 
 	message := fmt.Sprintf("item received: %s", name)
 	res := Response{Message: message}
@@ -95,125 +113,223 @@ func getItems(c echo.Context) error {
 func getItemByID(c echo.Context) error {
 	id := c.Param("itemID")
 
-	db, err := sql.Open("sqlite3", ItemsTable)
+	item, err := itemRepo.GetByID(id)
 	if err != nil {
-		c.Logger().Errorf("Failed to open database: %s", err)
-		return err
-	}
-
-	row := db.QueryRow("SELECT * FROM items WHERE id = ?", id)
-	var item Item
-	err = row.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName)
-	if err != nil {
-		if err.Error() == sql.ErrNoRows.Error() {
+		if err == sql.ErrNoRows {
 			c.Logger().Errorf("Item not found: %s", err)
 			return c.JSON(http.StatusNotFound, err)
-		} else {
-			c.Logger().Errorf("Failed to get item: %s", err)
-			return c.JSON(http.StatusInternalServerError, err)
 		}
+		c.Logger().Errorf("Failed to get item: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, item)
 }
 
 func getImg(c echo.Context) error {
-	// Create image path
-	imgPath := path.Join(ImgDir, c.Param("imageFilename"))
+	key := c.Param("imageFilename")
 
-	if !strings.HasSuffix(imgPath, ".jpg") {
-		res := Response{Message: "Image path does not end with .jpg"}
-		return c.JSON(http.StatusBadRequest, res)
+	rc, err := openImageOrDefault(c, key)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, Response{Message: "image not found"})
 	}
-	if _, err := os.Stat(imgPath); err != nil {
-		c.Logger().Debugf("Image not found: %s", imgPath)
-		imgPath = path.Join(ImgDir, "default.jpg")
+	defer rc.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(rc, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		c.Logger().Errorf("Failed to read image: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	contentType := http.DetectContentType(header[:n])
+
+	return c.Stream(http.StatusOK, contentType, io.MultiReader(bytes.NewReader(header[:n]), rc))
+}
+
+// openImageOrDefault opens key from the asset store, falling back to
+// images/default.jpg when key isn't found.
+func openImageOrDefault(c echo.Context, key string) (io.ReadCloser, error) {
+	rc, _, err := assetAgent.Open(c.Request().Context(), key)
+	if err == nil {
+		return rc, nil
 	}
 
-	return c.File(imgPath)
+	c.Logger().Debugf("Image not found: %s", key)
+	rc, _, err = assetAgent.Open(c.Request().Context(), "default.jpg")
+	return rc, err
 }
 
 func searchItems(c echo.Context) error {
 	keyword := c.QueryParam("keyword")
+	if keyword == "" {
+		return c.JSON(http.StatusOK, []Item{})
+	}
 
-	db, err := sql.Open("sqlite3", ItemsTable)
+	items, err := itemRepo.Search(keyword)
 	if err != nil {
-		c.Logger().Errorf("Failed to open database: %s", err)
+		c.Logger().Errorf("Failed to get items: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
 	}
 
-	rows, err := db.Query("SELECT * FROM items WHERE name LIKE ? ", "%"+keyword+"%")
+	return c.JSON(http.StatusOK, items)
+}
+
+// getSimilarItems returns items whose phash is within maxDistance Hamming
+// bits of the given item's, closest first, using the `hamming` SQL function
+// registered on sqliteDriver.
+func getSimilarItems(c echo.Context) error {
+	id := c.QueryParam("id")
+
+	maxDistance, err := strconv.Atoi(c.QueryParam("max_distance"))
 	if err != nil {
-		c.Logger().Errorf("Failed to get items: %s", err)
+		maxDistance = 8
+	}
+
+	var phash int64
+	if err := db.QueryRow("SELECT phash FROM items WHERE id = ?", id).Scan(&phash); err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, Response{Message: "item not found"})
+		}
+		c.Logger().Errorf("Failed to get item: %s", err)
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
+	rows, err := db.Query(
+		itemSelectColumns+`
+		 WHERE items.id != ? AND hamming(items.phash, ?) <= ?
+		 ORDER BY hamming(items.phash, ?)`,
+		id, phash, maxDistance, phash)
+	if err != nil {
+		c.Logger().Errorf("Failed to get similar items: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
 	defer rows.Close()
-	var items []Item
-	for rows.Next() {
-		var item Item
-		err = rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName)
-		if err != nil {
-			c.Logger().Errorf("Failed to get item: %s", err)
-			return c.JSON(http.StatusInternalServerError, err)
-		} else {
-			items = append(items, item)
-		}
+
+	items, err := scanItems(rows)
+	if err != nil {
+		c.Logger().Errorf("Failed to get item: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, items)
 }
 
-func addItemToDatabase(name string, category string, image *multipart.FileHeader) error {
-	hashedFileName := sha256.Sum256([]byte(image.Filename))
-	ext := path.Ext(image.Filename)
-	if ext != ".jpg" {
-		return fmt.Errorf("image extension is not jpg")
+// addItemToDatabase inserts name/category/image as a new item, along with
+// thumbnail/medium/original renditions in item_images. If an item with the
+// same image content (by SHA-256) already exists, that row is returned with
+// duplicate=true instead of inserting again. progress is called as the
+// upload moves through each phase; pass noopProgress if the caller doesn't
+// want them.
+func addItemToDatabase(name string, category string, imageFile *multipart.FileHeader, progress progressFunc) (item *Item, duplicate bool, err error) {
+	data, sha, err := readUpload(imageFile, progress)
+	if err != nil {
+		return nil, false, err
+	}
+	progress(uploadEvent{Status: "hashing"})
+
+	contentType, ext, err := sniffContentType(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing, err := itemRepo.GetBySHA(sha); err != nil {
+		return nil, false, err
+	} else if existing != nil {
+		return existing, true, nil
 	}
 
-	db, err := sql.Open("sqlite3", ItemsTable)
+	cat, err := categoryRepo.GetOrCreate(category)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS items (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, category TEXT, imageFileName TEXT)")
+	decoded, err := decodeImage(data)
 	if err != nil {
-		return err
+		return nil, false, badRequest("cannot decode image: %s", err)
+	}
+	bounds := decoded.Bounds()
+
+	newItem := &Item{
+		Name:          name,
+		CategoryID:    cat.ID,
+		Category:      cat.Name,
+		ImageFileName: sha + ext,
+		PHash:         int64(dHash(decoded)),
+		BlurHash:      encodeBlurHash(decoded, 4, 3),
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		ContentType:   contentType,
 	}
-	statement.Exec()
 
-	item := Item{}
-	item.Name = name
-	item.Category = category
-	item.ImageFileName = fmt.Sprintf("%x.jpg", hashedFileName)
+	progress(uploadEvent{Status: "writing"})
+	id, inserted, err := itemRepo.Insert(newItem, sha)
+	if err != nil {
+		return nil, false, err
+	}
+	if !inserted {
+		// Lost the race to a concurrent upload of the same content; sha256
+		// is UNIQUE, so fetch the row it inserted instead of ours.
+		existing, err := itemRepo.GetBySHA(sha)
+		if err != nil {
+			return nil, false, err
+		}
+		return existing, true, nil
+	}
+	newItem.ID = id
 
-	statement, _ = db.Prepare("INSERT INTO items (name, category, imageFileName) VALUES (?, ?, ?)")
-	statement.Exec(item.Name, item.Category, item.ImageFileName)
+	renditions, err := buildRenditions(decoded, data, contentType, ext, progress)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := storeRenditions(context.Background(), db, newItem.ID, renditions); err != nil {
+		return nil, false, err
+	}
 
-	return nil
+	return newItem, false, nil
 }
 
-func saveImageToLocal(image *multipart.FileHeader) {
-	src, err := image.Open()
+// readUpload copies imageFile into a temp file, hashing as it goes, and
+// returns the whole-file bytes alongside the hex SHA-256. The copy is
+// capped at maxUploadBytes and a larger file is rejected as a bad request.
+// The request body itself is not capped: c.FormFile has already parsed the
+// whole multipart form (in memory or in temp files) before this runs.
+// progress is fed "receiving" events as the copy reads that parsed file.
+func readUpload(imageFile *multipart.FileHeader, progress progressFunc) (data []byte, sha string, err error) {
+	src, err := imageFile.Open()
 	if err != nil {
-		fmt.Println("Cannot open image: ", err)
-		return
+		return nil, "", err
 	}
 	defer src.Close()
 
-	hashedName := sha256.Sum256([]byte(image.Filename))
-	imgPath := path.Join(ImgDirRelative, fmt.Sprintf("%x.jpg", hashedName))
+	tmp, err := ioutil.TempFile("", "item-upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	dst, err := os.Create(imgPath)
+	counted := &countingReader{r: src, total: imageFile.Size, onRead: func(current, total int64) {
+		progress(uploadEvent{Status: "receiving", Current: current, Total: total})
+	}}
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(counted, maxUploadBytes+1))
 	if err != nil {
-		fmt.Println("Cannot create image: ", err)
-		return
+		return nil, "", err
+	}
+	if written > maxUploadBytes {
+		return nil, "", badRequest("image exceeds %d byte limit", maxUploadBytes)
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		fmt.Println("Cannot copy image: ", err)
-		return
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
 	}
+	data, err = ioutil.ReadAll(tmp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
 func readItems() ([]byte, error) {
@@ -233,6 +349,39 @@ func readItems() ([]byte, error) {
 }
 
 func main() {
+	agent, err := NewAssetAgentFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	assetAgent = agent
+
+	sharedDB, err := sql.Open(sqliteDriver, ItemsTable)
+	if err != nil {
+		panic(err)
+	}
+	// WAL mode lets readers proceed while a writer holds the lock, so reads
+	// don't need to queue behind every write on a single connection; only
+	// busy_timeout's retry is needed to keep concurrent writers from
+	// erroring out with SQLITE_BUSY.
+	if _, err := sharedDB.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		panic(err)
+	}
+	if _, err := sharedDB.Exec("PRAGMA busy_timeout=5000"); err != nil {
+		panic(err)
+	}
+	sharedDB.SetMaxOpenConns(8)
+	if err := runMigrations(sharedDB); err != nil {
+		panic(err)
+	}
+	db = sharedDB
+
+	if itemRepo, err = NewItemRepository(db); err != nil {
+		panic(err)
+	}
+	if categoryRepo, err = NewCategoryRepository(db); err != nil {
+		panic(err)
+	}
+
 	e := echo.New()
 
 	// Middleware
@@ -252,10 +401,14 @@ func main() {
 	// Routes
 	e.GET("/", root)
 	e.GET("/items", getItems)
+	e.GET("/items/similar", getSimilarItems)
 	e.GET("/items/:itemID", getItemByID)
+	e.GET("/items/:itemID/image", getItemImage)
 	e.POST("/items", addItem)
 	e.GET("/image/:imageFilename", getImg)
 	e.GET("/search", searchItems)
+	e.GET("/categories", getCategories)
+	e.GET("/categories/:categoryID/items", getCategoryItems)
 
 	// Start server
 	e.Logger.Fatal(e.Start(":9000"))
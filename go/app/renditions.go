@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	purposeOriginal  = "original"
+	purposeMedium    = "medium"
+	purposeThumbnail = "thumbnail"
+
+	thumbnailWidth = 200
+	mediumWidth    = 800
+)
+
+// sizeToPurpose maps the ?size= query param on GET /items/:id/image to the
+// purpose column stored in item_images.
+var sizeToPurpose = map[string]string{
+	"thumb":    purposeThumbnail,
+	"medium":   purposeMedium,
+	"original": purposeOriginal,
+}
+
+// purposeToVariant is the inverse of sizeToPurpose, used to label
+// "transcoding" progress events with the same short names clients already
+// pass as ?size=.
+var purposeToVariant = map[string]string{
+	purposeThumbnail: "thumb",
+	purposeMedium:    "medium",
+	purposeOriginal:  "original",
+}
+
+type rendition struct {
+	purpose     string
+	data        []byte
+	contentType string
+	ext         string
+	width       int
+	height      int
+}
+
+// buildRenditions derives the thumbnail and medium renditions of decoded
+// and pairs them with the original upload, ready to be persisted by
+// storeRenditions. progress gets a "transcoding" event as each rendition
+// finishes, so a caller streaming progress sees them land as the (synchronous,
+// CPU-bound) resize work actually completes rather than all at once at the end.
+func buildRenditions(decoded image.Image, original []byte, originalContentType, originalExt string, progress progressFunc) ([]rendition, error) {
+	origBounds := decoded.Bounds()
+	renditions := []rendition{
+		{purposeOriginal, original, originalContentType, originalExt, origBounds.Dx(), origBounds.Dy()},
+	}
+	progress(uploadEvent{Status: "transcoding", Variant: purposeToVariant[purposeOriginal]})
+
+	for _, spec := range []struct {
+		purpose string
+		width   int
+	}{
+		{purposeThumbnail, thumbnailWidth},
+		{purposeMedium, mediumWidth},
+	} {
+		resized := resizeToWidth(decoded, spec.width)
+		data, err := encodeJPEG(resized)
+		if err != nil {
+			return nil, err
+		}
+		b := resized.Bounds()
+		renditions = append(renditions, rendition{spec.purpose, data, "image/jpeg", ".jpg", b.Dx(), b.Dy()})
+		progress(uploadEvent{Status: "transcoding", Variant: purposeToVariant[spec.purpose]})
+	}
+
+	return renditions, nil
+}
+
+// storeRenditions writes each rendition through the asset agent and records
+// it in item_images, keyed by (item_id, purpose).
+func storeRenditions(ctx context.Context, db *sql.DB, itemID int, renditions []rendition) error {
+	statement, err := db.Prepare(`INSERT OR REPLACE INTO item_images
+		(item_id, purpose, width, height, content_type, file_name) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range renditions {
+		key, _, err := assetAgent.Store(ctx, r.data, r.contentType, r.ext)
+		if err != nil {
+			return err
+		}
+		if _, err := statement.Exec(itemID, r.purpose, r.width, r.height, r.contentType, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getItemImage serves a specific rendition of an item's image, selected by
+// ?size=thumb|medium|original (default original).
+func getItemImage(c echo.Context) error {
+	itemID := c.Param("itemID")
+	size := c.QueryParam("size")
+	if size == "" {
+		size = "original"
+	}
+
+	purpose, ok := sizeToPurpose[size]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, Response{Message: fmt.Sprintf("unknown size %q", size)})
+	}
+
+	var fileName, contentType string
+	err := db.QueryRow("SELECT file_name, content_type FROM item_images WHERE item_id = ? AND purpose = ?", itemID, purpose).
+		Scan(&fileName, &contentType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, Response{Message: "image not found"})
+		}
+		c.Logger().Errorf("Failed to get item image: %s", err)
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+
+	rc, _, err := assetAgent.Open(c.Request().Context(), fileName)
+	if err != nil {
+		c.Logger().Errorf("Failed to open image: %s", err)
+		return c.JSON(http.StatusNotFound, Response{Message: "image not found"})
+	}
+	defer rc.Close()
+
+	return c.Stream(http.StatusOK, contentType, rc)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+
+	"golang.org/x/image/draw"
+)
+
+// resizeToWidth scales img down to width pixels wide, preserving aspect
+// ratio, using Catmull-Rom interpolation for a sharper result than the
+// stdlib's nearest-neighbor. Images no wider than width are not scaled,
+// only flattened as below. The destination is flattened onto a white background
+// first: renditions get re-encoded as JPEG, which has no alpha channel, so
+// drawing straight onto a zero-value (transparent black) RGBA would bake
+// transparent regions in as black instead of a sane background color.
+func resizeToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= width {
+		return flattenOnWhite(img)
+	}
+
+	height := srcH * width / srcW
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// flattenOnWhite composites img over a white background, dropping alpha so
+// a subsequent JPEG encode doesn't bake transparency in as black.
+func flattenOnWhite(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
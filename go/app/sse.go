@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// uploadEvent is one frame of the SSE progress stream emitted for
+// POST /items when the client opts in with Accept: text/event-stream.
+type uploadEvent struct {
+	Status        string `json:"status"`
+	Current       int64  `json:"current,omitempty"`
+	Total         int64  `json:"total,omitempty"`
+	Variant       string `json:"variant,omitempty"`
+	ID            int    `json:"id,omitempty"`
+	ImageFileName string `json:"imageFileName,omitempty"`
+}
+
+type progressFunc func(uploadEvent)
+
+func noopProgress(uploadEvent) {}
+
+// countingReader wraps the uploaded file's reader and calls onRead after
+// every Read, so readUpload can publish "receiving" progress. That file has
+// already been parsed out of the multipart form by c.FormFile, so the
+// events track the copy out of it, not the network transfer.
+type countingReader struct {
+	r       io.Reader
+	total   int64
+	current int64
+	onRead  func(current, total int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.current += int64(n)
+	if n > 0 && c.onRead != nil {
+		c.onRead(c.current, c.total)
+	}
+	return n, err
+}
+
+// wantsEventStream reports whether the client opted into the SSE upload
+// progress stream via Accept: text/event-stream. Clients that don't ask
+// for it keep getting the plain JSON response.
+func wantsEventStream(c echo.Context) bool {
+	for _, accept := range c.Request().Header["Accept"] {
+		if accept == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// addItemSSE runs addItemToDatabase on a goroutine, relaying its progress
+// to the client as Server-Sent Events instead of blocking on one JSON
+// response until the whole upload is processed.
+func addItemSSE(c echo.Context, name, category string, image *multipart.FileHeader) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	events := make(chan uploadEvent, 16)
+	var item *Item
+	var duplicate bool
+	var addErr error
+
+	go func() {
+		defer close(events)
+		item, duplicate, addErr = addItemToDatabase(name, category, image, func(e uploadEvent) {
+			events <- e
+		})
+	}()
+
+	for e := range events {
+		writeSSEEvent(w, e)
+	}
+
+	if addErr != nil {
+		c.Logger().Errorf("Failed to add item to database: %s", addErr)
+		writeSSEEvent(w, uploadEvent{Status: "error"})
+		return nil
+	}
+
+	if duplicate {
+		c.Logger().Infof("Duplicate image upload, reusing item %d", item.ID)
+	}
+	writeSSEEvent(w, uploadEvent{Status: "done", ID: item.ID, ImageFileName: item.ImageFileName})
+
+	return nil
+}
+
+func writeSSEEvent(w *echo.Response, e uploadEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	w.Flush()
+}
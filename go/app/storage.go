@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// runMigrations applies any .sql file under migrations/ that isn't yet
+// recorded in schema_migrations, in filename order, each inside its own
+// transaction.
+func runMigrations(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		var applied int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", entry.Name()).Scan(&applied); err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		if err := applyMigration(db, entry.Name(), string(contents)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, version, contents string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(contents); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s: %w", version, err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s: %w", version, err)
+	}
+
+	return tx.Commit()
+}
+
+// itemSelectColumns is shared by every ItemRepository query so a schema
+// change only has to update the column list in one place.
+const itemSelectColumns = `SELECT items.id, items.name, items.category_id, items.category,
+	items.imageFileName, items.sha256, items.phash, items.blurhash, items.width, items.height, items.content_type
+	FROM items`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows so scanItem can
+// back both a single-item lookup and a multi-row listing.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row rowScanner) (*Item, error) {
+	var item Item
+	var sha string
+	err := row.Scan(&item.ID, &item.Name, &item.CategoryID, &item.Category, &item.ImageFileName,
+		&sha, &item.PHash, &item.BlurHash, &item.Width, &item.Height, &item.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func scanItems(rows *sql.Rows) ([]Item, error) {
+	var items []Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// ItemRepository holds the prepared statements for the items table. The
+// one handler that queries items directly is getSimilarItems in main.go,
+// which reuses itemSelectColumns and scanItems.
+type ItemRepository struct {
+	db *sql.DB
+
+	insertStmt         *sql.Stmt
+	getByIDStmt        *sql.Stmt
+	getBySHAStmt       *sql.Stmt
+	searchStmt         *sql.Stmt
+	listByCategoryStmt *sql.Stmt
+}
+
+func NewItemRepository(db *sql.DB) (*ItemRepository, error) {
+	r := &ItemRepository{db: db}
+
+	var err error
+	if r.insertStmt, err = db.Prepare(`INSERT OR IGNORE INTO items
+		(name, category, category_id, imageFileName, sha256, phash, blurhash, width, height, content_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`); err != nil {
+		return nil, err
+	}
+	if r.getByIDStmt, err = db.Prepare(itemSelectColumns + " WHERE items.id = ?"); err != nil {
+		return nil, err
+	}
+	if r.getBySHAStmt, err = db.Prepare(itemSelectColumns + " WHERE items.sha256 = ?"); err != nil {
+		return nil, err
+	}
+	if r.searchStmt, err = db.Prepare(itemSelectColumns + " WHERE items.name LIKE ?"); err != nil {
+		return nil, err
+	}
+	if r.listByCategoryStmt, err = db.Prepare(itemSelectColumns + " WHERE items.category_id = ?"); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Insert adds a new item row and returns its id. sha256 is UNIQUE, so if a
+// concurrent request already inserted the same content hash, the INSERT OR
+// IGNORE is a no-op and inserted comes back false instead of racing a
+// separate check-then-insert.
+func (r *ItemRepository) Insert(item *Item, sha string) (id int, inserted bool, err error) {
+	res, err := r.insertStmt.Exec(item.Name, item.Category, item.CategoryID, item.ImageFileName,
+		sha, item.PHash, item.BlurHash, item.Width, item.Height, item.ContentType)
+	if err != nil {
+		return 0, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	if affected == 0 {
+		return 0, false, nil
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	return int(lastID), true, nil
+}
+
+func (r *ItemRepository) GetByID(id string) (*Item, error) {
+	return scanItem(r.getByIDStmt.QueryRow(id))
+}
+
+// GetBySHA returns (nil, nil) when no item has that content hash yet.
+func (r *ItemRepository) GetBySHA(sha string) (*Item, error) {
+	item, err := scanItem(r.getBySHAStmt.QueryRow(sha))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Search matches items whose name contains keyword. This stays a plain
+// LIKE scan rather than FTS5: the stock mattn/go-sqlite3 build this repo
+// ships (no build tag, no Makefile/Dockerfile to wire one into) doesn't
+// compile FTS5 in, and a user-supplied keyword would otherwise hit the
+// FTS5 query parser directly (reserved words, unbalanced quotes, a
+// leading hyphen) and surface as a raw driver error.
+func (r *ItemRepository) Search(keyword string) ([]Item, error) {
+	rows, err := r.searchStmt.Query("%" + keyword + "%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanItems(rows)
+}
+
+func (r *ItemRepository) ListByCategory(categoryID string) ([]Item, error) {
+	rows, err := r.listByCategoryStmt.Query(categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanItems(rows)
+}
+
+// CategoryRepository backs the category side of the items/category split.
+type CategoryRepository struct {
+	db *sql.DB
+
+	listStmt    *sql.Stmt
+	getByIDStmt *sql.Stmt
+}
+
+func NewCategoryRepository(db *sql.DB) (*CategoryRepository, error) {
+	r := &CategoryRepository{db: db}
+
+	var err error
+	if r.listStmt, err = db.Prepare("SELECT id, name FROM categories ORDER BY name"); err != nil {
+		return nil, err
+	}
+	if r.getByIDStmt, err = db.Prepare("SELECT id, name FROM categories WHERE id = ?"); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetOrCreate looks up a category by name, creating it if this is the
+// first item ever filed under it.
+func (r *CategoryRepository) GetOrCreate(name string) (*Category, error) {
+	var cat Category
+	err := r.db.QueryRow("SELECT id, name FROM categories WHERE name = ?", name).Scan(&cat.ID, &cat.Name)
+	if err == nil {
+		return &cat, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	res, err := r.db.Exec("INSERT INTO categories (name) VALUES (?)", name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Category{ID: int(id), Name: name}, nil
+}
+
+func (r *CategoryRepository) List() ([]Category, error) {
+	rows, err := r.listStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var cat Category
+		if err := rows.Scan(&cat.ID, &cat.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	return categories, rows.Err()
+}
+
+func (r *CategoryRepository) GetByID(id string) (*Category, error) {
+	var cat Category
+	if err := r.getByIDStmt.QueryRow(id).Scan(&cat.ID, &cat.Name); err != nil {
+		return nil, err
+	}
+	return &cat, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// newTestDB runs the migrations against a fresh on-disk sqlite file and
+// mirrors the pragmas main() sets, so tests exercise the same WAL +
+// busy_timeout behavior concurrent requests rely on in production.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "items-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp db: %s", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	db, err := sql.Open(sqliteDriver, f.Name())
+	if err != nil {
+		t.Fatalf("open db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		t.Fatalf("enable WAL: %s", err)
+	}
+	if _, err := db.Exec("PRAGMA busy_timeout=5000"); err != nil {
+		t.Fatalf("set busy_timeout: %s", err)
+	}
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("run migrations: %s", err)
+	}
+
+	return db
+}
+
+func TestItemRepository_InsertAndGetByID(t *testing.T) {
+	db := newTestDB(t)
+	itemRepo, err := NewItemRepository(db)
+	if err != nil {
+		t.Fatalf("new item repository: %s", err)
+	}
+	categoryRepo, err := NewCategoryRepository(db)
+	if err != nil {
+		t.Fatalf("new category repository: %s", err)
+	}
+
+	cat, err := categoryRepo.GetOrCreate("toys")
+	if err != nil {
+		t.Fatalf("get or create category: %s", err)
+	}
+
+	item := &Item{
+		Name:          "ball",
+		CategoryID:    cat.ID,
+		Category:      cat.Name,
+		ImageFileName: "abc123.jpg",
+		PHash:         42,
+		BlurHash:      "LKN]Rv",
+		Width:         100,
+		Height:        100,
+		ContentType:   "image/jpeg",
+	}
+	id, inserted, err := itemRepo.Insert(item, "sha-ball")
+	if err != nil {
+		t.Fatalf("insert: %s", err)
+	}
+	if !inserted {
+		t.Fatalf("expected a fresh sha256 to insert")
+	}
+
+	got, err := itemRepo.GetByID(strconv.Itoa(id))
+	if err != nil {
+		t.Fatalf("get by id: %s", err)
+	}
+	if got.Name != "ball" || got.CategoryID != cat.ID {
+		t.Fatalf("got %+v, want name=ball categoryID=%d", got, cat.ID)
+	}
+}
+
+func TestItemRepository_Insert_DuplicateSHAIsIgnored(t *testing.T) {
+	db := newTestDB(t)
+	itemRepo, err := NewItemRepository(db)
+	if err != nil {
+		t.Fatalf("new item repository: %s", err)
+	}
+	categoryRepo, err := NewCategoryRepository(db)
+	if err != nil {
+		t.Fatalf("new category repository: %s", err)
+	}
+	cat, err := categoryRepo.GetOrCreate("toys")
+	if err != nil {
+		t.Fatalf("get or create category: %s", err)
+	}
+
+	first := &Item{Name: "a", CategoryID: cat.ID, Category: cat.Name, ImageFileName: "a.jpg", ContentType: "image/jpeg"}
+	firstID, inserted, err := itemRepo.Insert(first, "dup-sha")
+	if err != nil || !inserted {
+		t.Fatalf("first insert: id=%d inserted=%v err=%s", firstID, inserted, err)
+	}
+
+	second := &Item{Name: "b", CategoryID: cat.ID, Category: cat.Name, ImageFileName: "b.jpg", ContentType: "image/jpeg"}
+	_, inserted, err = itemRepo.Insert(second, "dup-sha")
+	if err != nil {
+		t.Fatalf("second insert: %s", err)
+	}
+	if inserted {
+		t.Fatalf("expected second insert of the same sha256 to be ignored")
+	}
+
+	existing, err := itemRepo.GetBySHA("dup-sha")
+	if err != nil {
+		t.Fatalf("get by sha: %s", err)
+	}
+	if existing == nil || existing.ID != firstID {
+		t.Fatalf("expected GetBySHA to return the first row, got %+v", existing)
+	}
+}
+
+// TestItemRepository_ConcurrentInsertSameSHACollapsesToOneRow reproduces the
+// race the sha256 UNIQUE index is there to close: N concurrent uploads of
+// the same content must leave exactly one items row behind.
+func TestItemRepository_ConcurrentInsertSameSHACollapsesToOneRow(t *testing.T) {
+	db := newTestDB(t)
+	itemRepo, err := NewItemRepository(db)
+	if err != nil {
+		t.Fatalf("new item repository: %s", err)
+	}
+	categoryRepo, err := NewCategoryRepository(db)
+	if err != nil {
+		t.Fatalf("new category repository: %s", err)
+	}
+	cat, err := categoryRepo.GetOrCreate("toys")
+	if err != nil {
+		t.Fatalf("get or create category: %s", err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	inserted := make([]bool, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := &Item{Name: "dup", CategoryID: cat.ID, Category: cat.Name, ImageFileName: "dup.jpg", ContentType: "image/jpeg"}
+			_, inserted[i], errs[i] = itemRepo.Insert(item, "concurrent-sha")
+		}(i)
+	}
+	wg.Wait()
+
+	winners := 0
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("insert %d: %s", i, errs[i])
+		}
+		if inserted[i] {
+			winners++
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly 1 of %d concurrent inserts to win, got %d", n, winners)
+	}
+
+	var rowCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items WHERE sha256 = ?", "concurrent-sha").Scan(&rowCount); err != nil {
+		t.Fatalf("count rows: %s", err)
+	}
+	if rowCount != 1 {
+		t.Fatalf("expected 1 row for sha256, got %d", rowCount)
+	}
+}
+
+func TestCategoryRepository_GetOrCreateIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	categoryRepo, err := NewCategoryRepository(db)
+	if err != nil {
+		t.Fatalf("new category repository: %s", err)
+	}
+
+	first, err := categoryRepo.GetOrCreate("books")
+	if err != nil {
+		t.Fatalf("get or create: %s", err)
+	}
+	second, err := categoryRepo.GetOrCreate("books")
+	if err != nil {
+		t.Fatalf("get or create: %s", err)
+	}
+	if first.ID != second.ID {
+		t.Fatalf("expected repeat GetOrCreate to return the same category, got %d and %d", first.ID, second.ID)
+	}
+
+	categories, err := categoryRepo.List()
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	if len(categories) != 1 {
+		t.Fatalf("expected 1 category, got %d", len(categories))
+	}
+}